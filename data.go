@@ -41,8 +41,10 @@ func newContext(ctx context.Context, q Queryer) context.Context {
 }
 
 // RunInTransaction runs the f with the transaction queryable inside the context
-func (m *Manager) RunInTransaction(ctx context.Context, f func(tctx context.Context) error) error {
-	tx, err := m.db.Beginx()
+// and returns the commit error when f succeeds but the commit fails.
+// The transaction is rolled back if the context is cancelled before the commit
+func (m *Manager) RunInTransaction(ctx context.Context, f func(tctx context.Context) error) (err error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
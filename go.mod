@@ -1,8 +1,9 @@
 module github.com/payfazz/FST-Database-Handler
 
-go 1.12
+go 1.15
 
 require (
+	github.com/DATA-DOG/go-sqlmock v1.5.2
 	github.com/jmoiron/sqlx v1.3.1
 	github.com/lib/pq v1.10.0
 )
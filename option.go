@@ -0,0 +1,111 @@
+package data
+
+import "context"
+
+// QueryOption customizes the behavior of a single repository call
+type QueryOption func(*queryOptions)
+
+// queryOptions holds the per call settings resolved from the QueryOption list
+type queryOptions struct {
+	tableName      string
+	lock           string
+	excludeDeleted bool
+	predicates     []string
+	maxRows        int
+}
+
+// ForUpdate locks the selected rows with "FOR UPDATE"
+// This is the default for reads running inside a transaction
+func ForUpdate() QueryOption {
+	return func(o *queryOptions) {
+		o.lock = " FOR UPDATE"
+	}
+}
+
+// ForShare locks the selected rows with "FOR SHARE" instead of "FOR UPDATE"
+func ForShare() QueryOption {
+	return func(o *queryOptions) {
+		o.lock = " FOR SHARE"
+	}
+}
+
+// NoLock selects the rows without any lock, even inside a transaction
+func NoLock() QueryOption {
+	return func(o *queryOptions) {
+		o.lock = ""
+	}
+}
+
+// ExcludeDeleted skips the soft deleted rows (deleted_at IS NULL)
+// It has no effect when the element has no deleted_at column
+func ExcludeDeleted() QueryOption {
+	return func(o *queryOptions) {
+		o.excludeDeleted = true
+	}
+}
+
+// IncludeDeleted returns the soft deleted rows as well, for the methods
+// skipping them by default (Exists, Count and WhereMaps)
+func IncludeDeleted() QueryOption {
+	return func(o *queryOptions) {
+		o.excludeDeleted = false
+	}
+}
+
+// Predicate ANDs an extra immutable condition into the where clause,
+// e.g. the predicate of a partial index so the planner can use that index
+func Predicate(predicate string) QueryOption {
+	return func(o *queryOptions) {
+		o.predicates = append(o.predicates, predicate)
+	}
+}
+
+// MaxRows limits the number of rows returned by Where
+func MaxRows(n int) QueryOption {
+	return func(o *queryOptions) {
+		o.maxRows = n
+	}
+}
+
+// Table runs the call against tableName instead of the repository table,
+// e.g. a partition or an archive table sharing the same columns
+func Table(tableName string) QueryOption {
+	return func(o *queryOptions) {
+		o.tableName = tableName
+	}
+}
+
+// excludeDeletedByDefault prepends ExcludeDeleted for the methods skipping
+// soft deleted rows unless IncludeDeleted is given
+func excludeDeletedByDefault(opts []QueryOption) []QueryOption {
+	return append([]QueryOption{ExcludeDeleted()}, opts...)
+}
+
+// unlockedByDefault prepends NoLock for the methods locking the rows inside
+// a transaction only when a lock option is given
+func unlockedByDefault(opts []QueryOption) []QueryOption {
+	return append([]QueryOption{NoLock()}, opts...)
+}
+
+// buildOptions resolves the options without any transaction defaults
+func (r *PostgresRepository) buildOptions(opts []QueryOption) *queryOptions {
+	o := &queryOptions{
+		tableName: r.tableName,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// applyOptions returns the queryer for the context and the resolved options.
+// Reads inside a transaction are locked "FOR UPDATE" unless an option says otherwise.
+func (r *PostgresRepository) applyOptions(ctx context.Context, opts []QueryOption) (Queryer, *queryOptions) {
+	db := r.db
+	tx, ok := txFromContext(ctx)
+	if ok {
+		db = tx
+		opts = append([]QueryOption{ForUpdate()}, opts...)
+	}
+	return db, r.buildOptions(opts)
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"context"
+	"testing"
+)
+
+func TestApplyOptions(t *testing.T) {
+	r, _ := newMockRepository(t, testItem{})
+	txCtx := newContext(context.Background(), r.db)
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		opts []QueryOption
+		want queryOptions
+	}{
+		{"no transaction", context.Background(), nil, queryOptions{tableName: "items"}},
+		{"transaction", txCtx, nil, queryOptions{tableName: "items", lock: " FOR UPDATE"}},
+		{"for share", txCtx, []QueryOption{ForShare()}, queryOptions{tableName: "items", lock: " FOR SHARE"}},
+		{"no lock", txCtx, []QueryOption{NoLock()}, queryOptions{tableName: "items"}},
+		{"table", context.Background(), []QueryOption{Table("items_archive")}, queryOptions{tableName: "items_archive"}},
+		{"max rows", context.Background(), []QueryOption{MaxRows(10)}, queryOptions{tableName: "items", maxRows: 10}},
+		{"exclude deleted", context.Background(), []QueryOption{ExcludeDeleted()}, queryOptions{tableName: "items", excludeDeleted: true}},
+		{"include deleted", context.Background(), excludeDeletedByDefault([]QueryOption{IncludeDeleted()}), queryOptions{tableName: "items"}},
+		{"unlocked by default", txCtx, unlockedByDefault(nil), queryOptions{tableName: "items"}},
+		{"unlocked by default for share", txCtx, unlockedByDefault([]QueryOption{ForShare()}), queryOptions{tableName: "items", lock: " FOR SHARE"}},
+	}
+	for _, c := range cases {
+		_, o := r.applyOptions(c.ctx, c.opts)
+		if o.tableName != c.want.tableName || o.lock != c.want.lock ||
+			o.maxRows != c.want.maxRows || o.excludeDeleted != c.want.excludeDeleted {
+			t.Errorf("%s: got %+v, want %+v", c.name, *o, c.want)
+		}
+	}
+}
+
+func TestQueryOptionsSQL(t *testing.T) {
+	r := NewPostgresRepository(nil, "items", testItem{})
+
+	cases := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			"where options",
+			r.BuildWhere(`"code" = :code`, ExcludeDeleted(), MaxRows(10), ForShare(), Table("items_archive")),
+			`SELECT "id", "name", "code", "created_at", "updated_at", "deleted_at" FROM items_archive WHERE ("code" = :code) AND deleted_at IS NULL LIMIT 10 FOR SHARE`,
+		},
+		{
+			"select all exclude deleted",
+			r.BuildSelectAll("", "10", ExcludeDeleted()),
+			`SELECT "id", "name", "code", "created_at", "updated_at", "deleted_at" FROM items WHERE deleted_at IS NULL ORDER BY ID LIMIT 10 `,
+		},
+		{
+			"exists include deleted",
+			r.BuildExists(`"code" = :code`, IncludeDeleted()),
+			`SELECT EXISTS (SELECT 1 FROM items WHERE "code" = :code)`,
+		},
+		{
+			"exists explicit lock",
+			r.BuildExists(`"code" = :code`, ForShare()),
+			`SELECT EXISTS (SELECT 1 FROM items WHERE ("code" = :code) AND deleted_at IS NULL FOR SHARE)`,
+		},
+		{
+			"count ignores lock",
+			r.BuildCount(`"code" = :code`, Table("items_archive"), ForUpdate()),
+			`SELECT COUNT(*) FROM items_archive WHERE ("code" = :code) AND deleted_at IS NULL`,
+		},
+	}
+	for _, c := range cases {
+		if c.query != c.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", c.name, c.query, c.want)
+		}
+	}
+}
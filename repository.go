@@ -5,16 +5,22 @@ import "context"
 // GenericRepository represents the generic repository
 // for the domain models that matches with its data models
 type GenericRepository interface {
-	FindByID(ctx context.Context, elem interface{}, id interface{}) error
-	SelectAll(ctx context.Context, elem interface{}, orderBy string, limit string, arg interface{}) error
+	FindByID(ctx context.Context, elem interface{}, id interface{}, opts ...QueryOption) error
+	SelectAll(ctx context.Context, elem interface{}, orderBy string, limit string, arg interface{}, opts ...QueryOption) error
 	InsertBulk(ctx context.Context, elem []interface{}) error
 	InsertBulkWithCount(ctx context.Context, elem []interface{}) (int, error)
+	InsertBulkStream(ctx context.Context, in <-chan interface{}) (int, error)
 	Insert(ctx context.Context, elem interface{}, dest interface{}) error
+	UpsertWithStatus(ctx context.Context, elem interface{}, conflictCols []string, dest interface{}) (bool, error)
 	CustomQuery(ctx context.Context, stmt string, args []interface{}) ([]interface{}, error)
 	CustomAnyQuery(ctx context.Context, stmt string, arg interface{}) ([]interface{}, error)
-	Where(ctx context.Context, dest interface{}, where string, args interface{}) error
-	Single(ctx context.Context, elem interface{}, where string, args interface{}) error
-	Delete(ctx context.Context, where string, args interface{}) error
-	Update(ctx context.Context, fields string, where string, arg interface{}) error
-	PermanentDelete(ctx context.Context, where string, arg interface{}) error
+	Where(ctx context.Context, dest interface{}, where string, args interface{}, opts ...QueryOption) error
+	WhereMaps(ctx context.Context, where string, arg interface{}, opts ...QueryOption) ([]map[string]interface{}, error)
+	Single(ctx context.Context, elem interface{}, where string, args interface{}, opts ...QueryOption) error
+	Exists(ctx context.Context, where string, arg interface{}, opts ...QueryOption) (bool, error)
+	Count(ctx context.Context, where string, arg interface{}, opts ...QueryOption) (int, error)
+	Delete(ctx context.Context, where string, args interface{}, opts ...QueryOption) error
+	Update(ctx context.Context, fields string, where string, arg interface{}, opts ...QueryOption) error
+	UpdatePartial(ctx context.Context, elem interface{}, columns []string, where string, opts ...QueryOption) error
+	PermanentDelete(ctx context.Context, where string, arg interface{}, opts ...QueryOption) error
 }
@@ -2,6 +2,7 @@ package data
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
@@ -19,6 +20,10 @@ const (
 	aliasConst   = "A"
 )
 
+// ErrNoUpdatableColumns is returned when an update has no column to set
+// other than the "updated_at" timestamp
+var ErrNoUpdatableColumns = errors.New("no updatable columns")
+
 // PostgresRepository is the postgres implementation of generic repository
 type PostgresRepository struct {
 	db              Queryer
@@ -27,7 +32,8 @@ type PostgresRepository struct {
 	selectFields    string
 	insertFields    string
 	insertParams    string
-	updateSetFields string
+	upsertSetFields string
+	softDelete      bool
 }
 
 // NewPostgresRepository creates a new generic postgres repository
@@ -40,17 +46,18 @@ func NewPostgresRepository(db *sqlx.DB, tableName string, elem interface{}) *Pos
 		selectFields:    selectFields(elemType),
 		insertFields:    insertFields(elemType),
 		insertParams:    insertParams(elemType),
-		updateSetFields: updateSetFields(elemType),
+		upsertSetFields: upsertSetFields(elemType),
+		softDelete:      softDelete(elemType),
 	}
 }
 
 // FindByID finds an element by its id
 // it's defined in this project context that
 // the element id column in the db should be "id"
-func (r *PostgresRepository) FindByID(ctx context.Context, elem interface{}, id interface{}) error {
+func (r *PostgresRepository) FindByID(ctx context.Context, elem interface{}, id interface{}, opts ...QueryOption) error {
 	err := r.Single(ctx, elem, `"id" = :id`, map[string]interface{}{
 		"id": id,
-	})
+	}, opts...)
 	if err != nil {
 		return err
 	}
@@ -59,21 +66,10 @@ func (r *PostgresRepository) FindByID(ctx context.Context, elem interface{}, id
 }
 
 // SelectAll Select Without where limited by records
-func (r *PostgresRepository) SelectAll(ctx context.Context, dest interface{}, orderBy string, limit string, arg interface{}) error {
-	db := r.db
-	tx, ok := txFromContext(ctx)
-	forUpdate := ""
-	if ok {
-		db = tx
-		forUpdate = " FOR UPDATE"
-	}
+func (r *PostgresRepository) SelectAll(ctx context.Context, dest interface{}, orderBy string, limit string, arg interface{}, opts ...QueryOption) error {
+	db, o := r.applyOptions(ctx, opts)
 
-	if orderBy == "" {
-		orderBy = "ID"
-	}
-
-	statement, err := db.PrepareNamed(fmt.Sprintf(`SELECT %s FROM %s ORDER BY %s LIMIT %s %s`,
-		r.selectFields, r.tableName, orderBy, limit, forUpdate))
+	statement, err := db.PrepareNamed(r.buildSelectAll(orderBy, limit, o))
 	if err != nil {
 		return err
 	}
@@ -109,98 +105,26 @@ func (r *PostgresRepository) InsertBulkBase(ctx context.Context, elem []interfac
 	}
 
 	//prepare the statement
-	columnLength := 0
-	columnName := []string{}
-	for i := 0; i < r.elemType.NumField(); i++ {
-		dbTag := r.elemType.Field(i).Tag.Get("db")
-		if dbTag == "created_at" {
-			createdAtValue := fmt.Sprintf("%s", "created_at")
-			columnName = append(columnName, createdAtValue)
-			columnLength++
-		}
-		if dbTag == "updated_at" {
-			updatedAtValue := fmt.Sprintf("%s", "updated_at")
-			columnName = append(columnName, updatedAtValue)
-			columnLength++
-		}
-	}
-
-	for i := 0; i < r.elemType.NumField(); i++ {
-		dbTag := r.elemType.Field(i).Tag.Get("db")
-		if !emptyTag(dbTag) && !readOnlyTag(dbTag) {
-			columnName = append(columnName, fmt.Sprintf("%s", dbTag))
-			columnLength++
-		}
-	}
-
-	stmt := fmt.Sprintf(`INSERT INTO %s (%s) VALUES `, r.tableName, r.insertFields)
+	columnLength := r.bulkColumnLength()
+	stmt := r.bulkStmt()
 	sqlQuery := writeStmt(rowPerInsert, columnLength, stmt)
 	query, err := db.Prepare(sqlQuery)
 	if err != nil {
 		return count, err
 	}
+	defer query.Close()
 
 	bindValues := []interface{}{}
-	createTag := false
-	updateTag := false
 	for i, column := range elem {
-		// Add CreatedAt and UpdatedAt field
-		now := time.Now().UTC().Add(time.Hour * 7) //time.Now().UTC()
-		rows, ok := column.([]interface{})
-		if ok {
-			for j, row := range rows {
-				dbTag := r.elemType.Field(j).Tag.Get("db")
-				if !emptyTag(dbTag) && !readOnlyTag(dbTag) {
-					if r.elemType.Field(j).Type.Kind() == reflect.Int64 {
-						row = StringToInt(fmt.Sprintf("%s", row))
-						if err != nil {
-							return count, err
-						}
-					}
-					bindValues = append(bindValues, row)
-				}
-				if createdTag(dbTag) {
-					createTag = true
-				}
-				if updatedTag(dbTag) {
-					updateTag = true
-				}
-			}
-		} else {
-			s := reflect.Indirect(reflect.ValueOf(column))
-			for j := 0; j < r.elemType.NumField(); j++ {
-				dbTag := r.elemType.Field(j).Tag.Get("db")
-				if !emptyTag(dbTag) && !readOnlyTag(dbTag) {
-					bindValues = append(bindValues, reflect.Indirect(s.Field(j)).Interface())
-				}
-				if createdTag(dbTag) {
-					createTag = true
-				}
-				if updatedTag(dbTag) {
-					updateTag = true
-				}
-			}
-		}
-		// Created_at
-		if createTag {
-			bindValues = append(bindValues, now)
-		}
-		// Updated_at
-		if updateTag {
-			bindValues = append(bindValues, now)
-		}
+		bindValues = append(bindValues, r.bulkBindValues(column)...)
 
 		if (i+1)%rowPerInsert == 0 {
 			//format all vals at once
-			res, err := query.Exec(bindValues...)
+			affectedRowsCount, err := execBulk(ctx, query, bindValues)
 			if err != nil {
 				return count, err
 			}
-			affectedRowsCount, err := res.RowsAffected()
-			if err != nil {
-				return count, err
-			}
-			count = count + int(affectedRowsCount)
+			count = count + affectedRowsCount
 			bindValues = nil
 		}
 	}
@@ -216,21 +140,104 @@ func (r *PostgresRepository) InsertBulkBase(ctx context.Context, elem []interfac
 		if err != nil {
 			return count, err
 		}
-		res, err := query.Exec(bindValues...)
-		if err != nil {
-			return count, err
-		}
-		affectedRowsCount, err := res.RowsAffected()
+		defer query.Close()
+
+		affectedRowsCount, err := execBulk(ctx, query, bindValues)
 		if err != nil {
 			return count, err
 		}
-		count = count + int(affectedRowsCount)
+		count = count + affectedRowsCount
 		bindValues = nil
 	}
 
 	return count, nil
 }
 
+// bulkColumnLength returns the number of bind values per row of a bulk insert
+func (r *PostgresRepository) bulkColumnLength() int {
+	columnLength := 0
+	for i := 0; i < r.elemType.NumField(); i++ {
+		dbTag := r.elemType.Field(i).Tag.Get("db")
+		if createdTag(dbTag) || updatedTag(dbTag) {
+			columnLength++
+		}
+		if !emptyTag(dbTag) && !readOnlyTag(dbTag) {
+			columnLength++
+		}
+	}
+	return columnLength
+}
+
+// bulkStmt returns the bulk insert statement without its VALUES rows
+func (r *PostgresRepository) bulkStmt() string {
+	return fmt.Sprintf(`INSERT INTO %s (%s) VALUES `, r.tableName, r.insertFields)
+}
+
+// bulkBindValues returns the bind values of one bulk insert row
+// elem is either an element or a []interface{} of its field values
+func (r *PostgresRepository) bulkBindValues(elem interface{}) []interface{} {
+	bindValues := []interface{}{}
+	createTag := false
+	updateTag := false
+	// Add CreatedAt and UpdatedAt field
+	now := time.Now().UTC().Add(time.Hour * 7) //time.Now().UTC()
+	rows, ok := elem.([]interface{})
+	if ok {
+		for j, row := range rows {
+			dbTag := r.elemType.Field(j).Tag.Get("db")
+			if !emptyTag(dbTag) && !readOnlyTag(dbTag) {
+				if r.elemType.Field(j).Type.Kind() == reflect.Int64 {
+					row = StringToInt(fmt.Sprintf("%s", row))
+				}
+				bindValues = append(bindValues, row)
+			}
+			if createdTag(dbTag) {
+				createTag = true
+			}
+			if updatedTag(dbTag) {
+				updateTag = true
+			}
+		}
+	} else {
+		s := reflect.Indirect(reflect.ValueOf(elem))
+		for j := 0; j < r.elemType.NumField(); j++ {
+			dbTag := r.elemType.Field(j).Tag.Get("db")
+			if !emptyTag(dbTag) && !readOnlyTag(dbTag) {
+				bindValues = append(bindValues, reflect.Indirect(s.Field(j)).Interface())
+			}
+			if createdTag(dbTag) {
+				createTag = true
+			}
+			if updatedTag(dbTag) {
+				updateTag = true
+			}
+		}
+	}
+	// Created_at
+	if createTag {
+		bindValues = append(bindValues, now)
+	}
+	// Updated_at
+	if updateTag {
+		bindValues = append(bindValues, now)
+	}
+	return bindValues
+}
+
+// execBulk runs a prepared bulk insert and returns the inserted row count
+// A running insert is aborted when ctx is cancelled
+func execBulk(ctx context.Context, query *sql.Stmt, bindValues []interface{}) (int, error) {
+	res, err := query.ExecContext(ctx, bindValues...)
+	if err != nil {
+		return 0, err
+	}
+	affectedRowsCount, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affectedRowsCount), nil
+}
+
 // InsertBulk Call insertBulkbase without returning row count
 func (r *PostgresRepository) InsertBulk(ctx context.Context, elem []interface{}) error {
 	_, err := r.InsertBulkBase(ctx, elem)
@@ -242,6 +249,92 @@ func (r *PostgresRepository) InsertBulkWithCount(ctx context.Context, elem []int
 	return r.InsertBulkBase(ctx, elem)
 }
 
+// InsertBulkStream inserts the elements received from in until the channel is closed.
+// Elements are buffered and flushed every rowPerInsert rows through one prepared
+// statement, so memory stays bounded whatever the stream length.
+// All batches run in one transaction: the one in the context or a new one.
+// It stops on the first error or when the context is cancelled, aborting a running
+// batch, rolls back the new transaction and returns the inserted row count.
+func (r *PostgresRepository) InsertBulkStream(ctx context.Context, in <-chan interface{}) (int, error) {
+	if tx, ok := txFromContext(ctx); ok {
+		return r.insertBulkStream(ctx, tx, in)
+	}
+
+	db, ok := r.db.(*sqlx.DB)
+	if !ok {
+		return 0, errors.New("InsertBulkStream needs a transaction in the context")
+	}
+
+	count := 0
+	err := NewManager(db).RunInTransaction(ctx, func(tctx context.Context) error {
+		tx, _ := txFromContext(tctx)
+		var err error
+		count, err = r.insertBulkStream(tctx, tx, in)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *PostgresRepository) insertBulkStream(ctx context.Context, db Queryer, in <-chan interface{}) (int, error) {
+	count := 0
+	columnLength := r.bulkColumnLength()
+	stmt := r.bulkStmt()
+
+	// The full batch statement is prepared once, on the first full batch
+	var query *sql.Stmt
+	defer func() {
+		if query != nil {
+			query.Close()
+		}
+	}()
+
+	rows := 0
+	bindValues := make([]interface{}, 0, rowPerInsert*columnLength)
+	for {
+		select {
+		case <-ctx.Done():
+			return count, ctx.Err()
+		case elem, ok := <-in:
+			if !ok {
+				if rows == 0 {
+					return count, nil
+				}
+				remainder, err := db.Prepare(writeStmt(rows, columnLength, stmt))
+				if err != nil {
+					return count, err
+				}
+				defer remainder.Close()
+
+				affectedRowsCount, err := execBulk(ctx, remainder, bindValues)
+				return count + affectedRowsCount, err
+			}
+
+			bindValues = append(bindValues, r.bulkBindValues(elem)...)
+			rows++
+			if rows == rowPerInsert {
+				if query == nil {
+					var err error
+					query, err = db.Prepare(writeStmt(rowPerInsert, columnLength, stmt))
+					if err != nil {
+						return count, err
+					}
+				}
+
+				affectedRowsCount, err := execBulk(ctx, query, bindValues)
+				count = count + affectedRowsCount
+				if err != nil {
+					return count, err
+				}
+				bindValues = bindValues[:0]
+				rows = 0
+			}
+		}
+	}
+}
+
 // Insert inserts a new element into the database.
 // It assumes the primary key of the table is "id" with serial type.
 // It will set the "owner" field of the element with the current account in the context if exists.
@@ -254,9 +347,7 @@ func (r *PostgresRepository) Insert(ctx context.Context, elem interface{}, dest
 		db = tx
 	}
 
-	query := `INSERT INTO %s (%s) VALUES (%s) RETURNING %s`
-	query = fmt.Sprintf(query, r.tableName, r.insertFields, r.insertParams, r.selectFields)
-	statement, err := db.PrepareNamed(query)
+	statement, err := db.PrepareNamed(r.BuildInsert())
 	if err != nil {
 		return err
 	}
@@ -269,20 +360,55 @@ func (r *PostgresRepository) Insert(ctx context.Context, elem interface{}, dest
 	return nil
 }
 
-// Single queries an element according to the query & argument provided
-// This function should be used only when fetching 1 row of data
-func (r *PostgresRepository) Single(ctx context.Context, elem interface{}, where string, arg interface{}) error {
+// UpsertWithStatus inserts the element, or updates the existing row when it
+// conflicts on conflictCols, and scans the resulting row into dest.
+// dest must be a pointer to the repository element type.
+// It reports whether the row was inserted (true) or updated (false).
+// The status relies on the Postgres "xmax = 0" heuristic: a freshly inserted
+// row has no deleting transaction, while a row touched by ON CONFLICT DO UPDATE
+// carries the updating transaction id in xmax. This is reliable for plain
+// tables but not guaranteed by Postgres (e.g. rows locked by other transactions).
+func (r *PostgresRepository) UpsertWithStatus(ctx context.Context, elem interface{}, conflictCols []string, dest interface{}) (bool, error) {
 	db := r.db
 	tx, ok := txFromContext(ctx)
-	forUpdate := ""
 	if ok {
 		db = tx
-		forUpdate = " FOR UPDATE"
 	}
 
-	statement, err := db.PrepareNamed(fmt.Sprintf(`SELECT %s FROM %s WHERE %s %s LIMIT 1`,
-		r.selectFields, r.tableName, where, forUpdate))
+	if len(conflictCols) == 0 {
+		return false, errors.New("There Must be Conflict Columns for Upsert Process")
+	}
+
+	if r.upsertSetFields == "" {
+		return false, ErrNoUpdatableColumns
+	}
+
+	targets, err := r.scanTargets(dest)
+	if err != nil {
+		return false, err
+	}
+
+	statement, err := db.PrepareNamed(r.BuildUpsert(conflictCols))
+	if err != nil {
+		return false, err
+	}
+	defer statement.Close()
 
+	inserted := false
+	targets = append(targets, &inserted)
+	err = statement.QueryRowx(r.insertArgs(elem)).Scan(targets...)
+	if err != nil {
+		return false, err
+	}
+	return inserted, nil
+}
+
+// Single queries an element according to the query & argument provided
+// This function should be used only when fetching 1 row of data
+func (r *PostgresRepository) Single(ctx context.Context, elem interface{}, where string, arg interface{}, opts ...QueryOption) error {
+	db, o := r.applyOptions(ctx, opts)
+
+	statement, err := db.PrepareNamed(r.buildSingle(where, o))
 	if err != nil {
 		return err
 	}
@@ -401,18 +527,10 @@ func (r *PostgresRepository) CustomAnyQuery(ctx context.Context, stmt string, ar
 
 // Where queries the elements according to the query & argument provided
 // This function should be used only when fetching more than 1 row of data
-func (r *PostgresRepository) Where(ctx context.Context, dest interface{}, where string, arg interface{}) error {
+func (r *PostgresRepository) Where(ctx context.Context, dest interface{}, where string, arg interface{}, opts ...QueryOption) error {
+	db, o := r.applyOptions(ctx, opts)
 
-	db := r.db
-	tx, ok := txFromContext(ctx)
-	forUpdate := ""
-	if ok {
-		db = tx
-		forUpdate = " FOR UPDATE"
-	}
-
-	statement, err := db.PrepareNamed(fmt.Sprintf(`SELECT %s FROM %s WHERE %s%s`,
-		r.selectFields, r.tableName, where, forUpdate))
+	statement, err := db.PrepareNamed(r.buildWhere(where, o))
 	if err != nil {
 		return err
 	}
@@ -425,19 +543,95 @@ func (r *PostgresRepository) Where(ctx context.Context, dest interface{}, where
 	return nil
 }
 
+// WhereMaps queries the elements according to the query & argument provided
+// and returns every row as a map keyed by the db column name.
+// Only the repository columns are selected and []byte values are returned as string.
+// Soft deleted rows are skipped; pass IncludeDeleted() to get them back.
+func (r *PostgresRepository) WhereMaps(ctx context.Context, where string, arg interface{}, opts ...QueryOption) ([]map[string]interface{}, error) {
+	db, o := r.applyOptions(ctx, excludeDeletedByDefault(opts))
+
+	statement, err := db.PrepareNamed(r.buildWhere(where, o))
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	rows, err := statement.Queryx(arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payload := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		m := map[string]interface{}{}
+		err := rows.MapScan(m)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range m {
+			if b, ok := v.([]byte); ok {
+				m[k] = string(b)
+			}
+		}
+		payload = append(payload, m)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// Exists checks whether any element matches the query & argument provided.
+// Rows with "deleted_at" set are skipped when the element has a deleted_at column.
+// Predicate options add extra immutable conditions, e.g. the predicate of a
+// partial index, so the planner can serve the check from that index.
+// Unlike the other reads the rows are not locked by default inside a transaction;
+// an explicit ForUpdate or ForShare locks the matching rows in the EXISTS subquery.
+func (r *PostgresRepository) Exists(ctx context.Context, where string, arg interface{}, opts ...QueryOption) (bool, error) {
+	db, o := r.applyOptions(ctx, excludeDeletedByDefault(unlockedByDefault(opts)))
+
+	statement, err := db.PrepareNamed(r.buildExists(where, o))
+	if err != nil {
+		return false, err
+	}
+	defer statement.Close()
+
+	exists := false
+	err = statement.Get(&exists, arg)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// Count counts the elements matching the query & argument provided.
+// It filters soft deleted rows and applies the options the same way as Exists,
+// except the lock options: Postgres does not allow FOR UPDATE/FOR SHARE with COUNT(*).
+func (r *PostgresRepository) Count(ctx context.Context, where string, arg interface{}, opts ...QueryOption) (int, error) {
+	db, o := r.applyOptions(ctx, excludeDeletedByDefault(opts))
+
+	statement, err := db.PrepareNamed(r.buildCount(where, o))
+	if err != nil {
+		return 0, err
+	}
+	defer statement.Close()
+
+	count := 0
+	err = statement.Get(&count, arg)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
 // Delete deletes the elem from database.
 // Delete not really deletes the elem from the db, but it will set the
 // "deletedAt" column to current time.
-func (r *PostgresRepository) Delete(ctx context.Context, where string, arg interface{}) error {
-	db := r.db
-	tx, ok := txFromContext(ctx)
-	if ok {
-		db = tx
-	}
+func (r *PostgresRepository) Delete(ctx context.Context, where string, arg interface{}, opts ...QueryOption) error {
+	db, o := r.applyOptions(ctx, opts)
 
-	statement, err := db.PrepareNamed(fmt.Sprintf(`
-		UPDATE %s SET "deleted_at" = :deleted_at
-				WHERE %s`, r.tableName, where))
+	statement, err := db.PrepareNamed(r.buildDelete(where, o))
 	if err != nil {
 		return err
 	}
@@ -450,19 +644,14 @@ func (r *PostgresRepository) Delete(ctx context.Context, where string, arg inter
 }
 
 // PermanentDelete Delete data rows From Database (USE WITH CAUTION)
-func (r *PostgresRepository) PermanentDelete(ctx context.Context, where string, arg interface{}) error {
-	db := r.db
-	tx, ok := txFromContext(ctx)
-	if ok {
-		db = tx
-	}
+func (r *PostgresRepository) PermanentDelete(ctx context.Context, where string, arg interface{}, opts ...QueryOption) error {
+	db, o := r.applyOptions(ctx, opts)
 
 	if arg == nil {
 		return errors.New("There Must be Where condition for Deletion Process")
 	}
 
-	statement, err := db.PrepareNamed(fmt.Sprintf(`
-		DELETE FROM %s WHERE %s`, r.tableName, where))
+	statement, err := db.PrepareNamed(r.buildPermanentDelete(where, o))
 	if err != nil {
 		return err
 	}
@@ -475,16 +664,13 @@ func (r *PostgresRepository) PermanentDelete(ctx context.Context, where string,
 }
 
 // Update Update records from specific table with specific criteria
-func (r *PostgresRepository) Update(ctx context.Context, fields string, where string, arg interface{}) error {
-	db := r.db
-	tx, ok := txFromContext(ctx)
-	if ok {
-		db = tx
+// It returns ErrNoUpdatableColumns when fields is empty or only sets "updated_at"
+func (r *PostgresRepository) Update(ctx context.Context, fields string, where string, arg interface{}, opts ...QueryOption) error {
+	db, o := r.applyOptions(ctx, opts)
+	if strings.TrimSpace(fields) == "" || onlyUpdatedAt(fields) {
+		return ErrNoUpdatableColumns
 	}
-	alias := aliasConst
-	statement, err := db.PrepareNamed(fmt.Sprintf(`
-		UPDATE %s %s SET %s
-				WHERE %s`, r.tableName, alias, fields, where))
+	statement, err := db.PrepareNamed(r.buildUpdate(fields, where, o))
 	if err != nil {
 		return err
 	}
@@ -496,6 +682,45 @@ func (r *PostgresRepository) Update(ctx context.Context, fields string, where st
 	return nil
 }
 
+// UpdatePartial updates only the given columns of the records matching where
+// with the values of elem, and bumps "updated_at" when the element has it.
+// The where clause binds against the elem fields, e.g. `"id" = :id`.
+// It returns ErrNoUpdatableColumns when columns is empty or only holds read only columns.
+func (r *PostgresRepository) UpdatePartial(ctx context.Context, elem interface{}, columns []string, where string, opts ...QueryOption) error {
+	db, o := r.applyOptions(ctx, opts)
+
+	fields, err := partialSetFields(r.elemType, columns)
+	if err != nil {
+		return err
+	}
+
+	statement, err := db.PrepareNamed(r.buildUpdate(fields, where, o))
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(r.updateArgs(elem))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *PostgresRepository) updateArgs(elem interface{}) map[string]interface{} {
+	res := map[string]interface{}{}
+	v := reflect.Indirect(reflect.ValueOf(elem))
+	for i := 0; i < r.elemType.NumField(); i++ {
+		dbTag := r.elemType.Field(i).Tag.Get("db")
+		if !emptyTag(dbTag) {
+			res[dbTag] = v.Field(i).Interface()
+		}
+	}
+
+	res["updated_at"] = time.Now().UTC().Add(time.Hour * 7) //time.Now().UTC()
+	return res
+}
+
 func (r *PostgresRepository) insertArgs(elem interface{}) map[string]interface{} {
 	res := map[string]interface{}{}
 	v := reflect.Indirect(reflect.ValueOf(elem))
@@ -512,6 +737,24 @@ func (r *PostgresRepository) insertArgs(elem interface{}) map[string]interface{}
 	return res
 }
 
+// scanTargets returns pointers to the dest fields in the same order as selectFields
+func (r *PostgresRepository) scanTargets(dest interface{}) ([]interface{}, error) {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.Elem().Type() != r.elemType {
+		return nil, errors.New("dest must be a pointer to the repository element type")
+	}
+
+	v = v.Elem()
+	targets := []interface{}{}
+	for i := 0; i < r.elemType.NumField(); i++ {
+		dbTag := r.elemType.Field(i).Tag.Get("db")
+		if !emptyTag(dbTag) {
+			targets = append(targets, v.Field(i).Addr().Interface())
+		}
+	}
+	return targets, nil
+}
+
 // txFromContext returns the trasanction object from the context
 func txFromContext(ctx context.Context) (Queryer, bool) {
 	q, ok := ctx.Value(TXCONTEXTKEY).(Queryer)
@@ -591,16 +834,96 @@ func insertParams(elemType reflect.Type) string {
 	return strings.Join(dbParams, ", ")
 }
 
-func updateSetFields(elemType reflect.Type) string {
-	setFields := []string{`"updated_at" = :updated_at`}
+func upsertSetFields(elemType reflect.Type) string {
+	setFields := []string{}
+	updateTag := false
 	for i := 0; i < elemType.NumField(); i++ {
 		field := elemType.Field(i)
 		dbTag := field.Tag.Get("db")
 		if !readOnlyTag(dbTag) && !emptyTag(dbTag) {
-			setFields = append(setFields, fmt.Sprintf(`"%s" = :%s`, dbTag, dbTag))
+			setFields = append(setFields, fmt.Sprintf(`"%s" = EXCLUDED."%s"`, dbTag, dbTag))
+		}
+		if updatedTag(dbTag) {
+			updateTag = true
 		}
 	}
-	return strings.Join(setFields, ",")
+	if updateTag {
+		setFields = append(setFields, `"updated_at" = EXCLUDED."updated_at"`)
+	}
+	return strings.Join(setFields, ", ")
+}
+
+func partialSetFields(elemType reflect.Type, columns []string) (string, error) {
+	setFields := []string{}
+	for _, col := range columns {
+		if !hasColumn(elemType, col) {
+			return "", fmt.Errorf("unknown column %s", col)
+		}
+		if !readOnlyTag(col) {
+			setFields = append(setFields, fmt.Sprintf(`"%s" = :%s`, col, col))
+		}
+	}
+	if len(setFields) == 0 {
+		return "", ErrNoUpdatableColumns
+	}
+
+	if hasColumn(elemType, "updated_at") {
+		setFields = append(setFields, `"updated_at" = :updated_at`)
+	}
+	return strings.Join(setFields, ","), nil
+}
+
+// onlyUpdatedAt reports whether every assignment of the SET list targets "updated_at"
+func onlyUpdatedAt(fields string) bool {
+	for _, assignment := range splitAssignments(fields) {
+		column := strings.TrimSpace(strings.SplitN(assignment, "=", 2)[0])
+		column = strings.TrimPrefix(column, aliasConst+".")
+		if !updatedTag(strings.Trim(column, `"`)) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitAssignments splits a SET list on the commas outside of parentheses
+// and string literals, e.g. the arguments of GREATEST(:updated_at, now())
+func splitAssignments(fields string) []string {
+	assignments := []string{}
+	depth := 0
+	quoted := false
+	start := 0
+	for i, c := range fields {
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			assignments = append(assignments, fields[start:i])
+			start = i + 1
+		}
+	}
+	return append(assignments, fields[start:])
+}
+
+// hasColumn reports whether the element has a field tagged with the db column
+func hasColumn(elemType reflect.Type, column string) bool {
+	if emptyTag(column) {
+		return false
+	}
+	for i := 0; i < elemType.NumField(); i++ {
+		if elemType.Field(i).Tag.Get("db") == column {
+			return true
+		}
+	}
+	return false
+}
+
+func softDelete(elemType reflect.Type) bool {
+	return hasColumn(elemType, "deleted_at")
 }
 
 func idTag(dbTag string) bool {
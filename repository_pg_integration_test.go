@@ -0,0 +1,148 @@
+//go:build integration
+// +build integration
+
+package data
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// The integration tests run against the database in POSTGRES_DSN:
+//   POSTGRES_DSN=postgres://... go test -tags integration ./...
+
+const integrationTable = "fst_database_handler_items"
+
+func integrationRepository(t *testing.T) (*PostgresRepository, *sqlx.DB) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN is not set")
+	}
+
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+
+	db.MustExec(`DROP TABLE IF EXISTS ` + integrationTable)
+	db.MustExec(`CREATE TABLE ` + integrationTable + ` (
+		"id" serial PRIMARY KEY,
+		"name" text NOT NULL UNIQUE,
+		"code" text NOT NULL,
+		"created_at" timestamp NOT NULL,
+		"updated_at" timestamp NOT NULL,
+		"deleted_at" timestamp
+	)`)
+	return NewPostgresRepository(db, integrationTable, testItem{}), db
+}
+
+func dropIntegrationTable(db *sqlx.DB) {
+	db.MustExec(`DROP TABLE IF EXISTS ` + integrationTable)
+	db.Close()
+}
+
+func TestIntegrationUpsertWithStatus(t *testing.T) {
+	r, db := integrationRepository(t)
+	defer dropIntegrationTable(db)
+	ctx := context.Background()
+
+	first := testItem{}
+	inserted, err := r.UpsertWithStatus(ctx, testItem{Name: "a", Code: "x"}, []string{"name"}, &first)
+	if err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if !inserted || first.ID == 0 || first.Code != "x" {
+		t.Errorf("insert: inserted = %v, dest = %+v", inserted, first)
+	}
+
+	second := testItem{}
+	inserted, err = r.UpsertWithStatus(ctx, testItem{Name: "a", Code: "y"}, []string{"name"}, &second)
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if inserted || second.ID != first.ID || second.Code != "y" {
+		t.Errorf("update: inserted = %v, dest = %+v", inserted, second)
+	}
+}
+
+func TestIntegrationExistsUsesPartialIndex(t *testing.T) {
+	r, db := integrationRepository(t)
+	defer dropIntegrationTable(db)
+
+	index := integrationTable + "_code_live"
+	db.MustExec(`CREATE INDEX ` + index + ` ON ` + integrationTable + ` ("code") WHERE deleted_at IS NULL`)
+	db.MustExec(`INSERT INTO ` + integrationTable + ` ("name", "code", "created_at", "updated_at", "deleted_at")
+		SELECT 'n' || i, 'c' || (i % 100), now(), now(), CASE WHEN i % 3 = 0 THEN now() END
+		FROM generate_series(1, 10000) AS i`)
+	db.MustExec(`ANALYZE ` + integrationTable)
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+	tx.MustExec(`SET LOCAL enable_seqscan = off`)
+
+	arg := map[string]interface{}{"code": "c1"}
+	for name, query := range map[string]string{
+		"exists": r.BuildExists(`"code" = :code`),
+		"count":  r.BuildCount(`"code" = :code`),
+	} {
+		statement, err := tx.PrepareNamed("EXPLAIN " + query)
+		if err != nil {
+			t.Fatalf("%s: prepare: %v", name, err)
+		}
+		plan := []string{}
+		if err := statement.Select(&plan, arg); err != nil {
+			t.Fatalf("%s: explain: %v", name, err)
+		}
+		if !strings.Contains(strings.Join(plan, "\n"), index) {
+			t.Errorf("%s does not use %s:\n%s", name, index, strings.Join(plan, "\n"))
+		}
+	}
+
+	exists, err := r.Exists(context.Background(), `"code" = :code`, arg)
+	if err != nil || !exists {
+		t.Errorf("Exists = %v, %v", exists, err)
+	}
+	count, err := r.Count(context.Background(), `"code" = :code`, arg)
+	if err != nil || count != 67 {
+		t.Errorf("Count = %v, %v, want 67", count, err)
+	}
+}
+
+func TestIntegrationWhereMaps(t *testing.T) {
+	r, db := integrationRepository(t)
+	defer dropIntegrationTable(db)
+	ctx := context.Background()
+
+	for _, name := range []string{"a", "b"} {
+		dest := testItem{}
+		if err := r.Insert(ctx, testItem{Name: name, Code: "x"}, &dest); err != nil {
+			t.Fatalf("insert %s: %v", name, err)
+		}
+	}
+	err := r.Delete(ctx, `"name" = :name`, map[string]interface{}{"name": "b", "deleted_at": time.Now()})
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+
+	arg := map[string]interface{}{"code": "x"}
+	rows, err := r.WhereMaps(ctx, `"code" = :code`, arg)
+	if err != nil {
+		t.Fatalf("WhereMaps: %v", err)
+	}
+	if len(rows) != 1 || rows[0]["name"] != "a" {
+		t.Errorf("WhereMaps = %v, want only a", rows)
+	}
+
+	rows, err = r.WhereMaps(ctx, `"code" = :code`, arg, IncludeDeleted())
+	if err != nil || len(rows) != 2 {
+		t.Errorf("WhereMaps IncludeDeleted = %v, %v, want 2 rows", rows, err)
+	}
+}
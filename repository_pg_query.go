@@ -0,0 +1,178 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The Build* methods return the exact SQL (before binding) executed by the
+// matching repository methods outside of a transaction, without touching the database.
+// Inside a transaction the read queries additionally carry a " FOR UPDATE" lock,
+// unless one of the lock options is given.
+
+// BuildSelectAll returns the statement executed by SelectAll
+func (r *PostgresRepository) BuildSelectAll(orderBy string, limit string, opts ...QueryOption) string {
+	return r.buildSelectAll(orderBy, limit, r.buildOptions(opts))
+}
+
+// BuildInsert returns the statement executed by Insert
+func (r *PostgresRepository) BuildInsert() string {
+	query := `INSERT INTO %s (%s) VALUES (%s) RETURNING %s`
+	return fmt.Sprintf(query, r.tableName, r.insertFields, r.insertParams, r.selectFields)
+}
+
+// BuildUpsert returns the statement executed by UpsertWithStatus
+func (r *PostgresRepository) BuildUpsert(conflictCols []string) string {
+	conflictFields := make([]string, 0, len(conflictCols))
+	for _, col := range conflictCols {
+		conflictFields = append(conflictFields, fmt.Sprintf(`"%s"`, col))
+	}
+
+	query := `INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s RETURNING %s, (xmax = 0) AS inserted`
+	return fmt.Sprintf(query, r.tableName, r.insertFields, r.insertParams,
+		strings.Join(conflictFields, ", "), r.upsertSetFields, r.selectFields)
+}
+
+// BuildSingle returns the statement executed by Single
+func (r *PostgresRepository) BuildSingle(where string, opts ...QueryOption) string {
+	return r.buildSingle(where, r.buildOptions(opts))
+}
+
+// BuildWhere returns the statement executed by Where
+func (r *PostgresRepository) BuildWhere(where string, opts ...QueryOption) string {
+	return r.buildWhere(where, r.buildOptions(opts))
+}
+
+// BuildExists returns the statement executed by Exists
+func (r *PostgresRepository) BuildExists(where string, opts ...QueryOption) string {
+	return r.buildExists(where, r.buildOptions(excludeDeletedByDefault(opts)))
+}
+
+// BuildCount returns the statement executed by Count
+func (r *PostgresRepository) BuildCount(where string, opts ...QueryOption) string {
+	return r.buildCount(where, r.buildOptions(excludeDeletedByDefault(opts)))
+}
+
+// BuildUpdate returns the statement executed by Update
+func (r *PostgresRepository) BuildUpdate(fields string, where string, opts ...QueryOption) string {
+	return r.buildUpdate(fields, where, r.buildOptions(opts))
+}
+
+// BuildUpdatePartial returns the statement executed by UpdatePartial
+func (r *PostgresRepository) BuildUpdatePartial(columns []string, where string, opts ...QueryOption) (string, error) {
+	fields, err := partialSetFields(r.elemType, columns)
+	if err != nil {
+		return "", err
+	}
+	return r.buildUpdate(fields, where, r.buildOptions(opts)), nil
+}
+
+// BuildDelete returns the statement executed by Delete
+func (r *PostgresRepository) BuildDelete(where string, opts ...QueryOption) string {
+	return r.buildDelete(where, r.buildOptions(opts))
+}
+
+// BuildPermanentDelete returns the statement executed by PermanentDelete
+func (r *PostgresRepository) BuildPermanentDelete(where string, opts ...QueryOption) string {
+	return r.buildPermanentDelete(where, r.buildOptions(opts))
+}
+
+func (r *PostgresRepository) buildSelectAll(orderBy string, limit string, o *queryOptions) string {
+	if orderBy == "" {
+		orderBy = "ID"
+	}
+
+	where := ""
+	if conditions := r.optionConditions(o); len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	return fmt.Sprintf(`SELECT %s FROM %s%s ORDER BY %s LIMIT %s %s`,
+		r.selectFields, o.tableName, where, orderBy, limit, o.lock)
+}
+
+func (r *PostgresRepository) buildSingle(where string, o *queryOptions) string {
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE %s %s LIMIT 1`,
+		r.selectFields, o.tableName, r.optionWhere(where, o), o.lock)
+}
+
+func (r *PostgresRepository) buildWhere(where string, o *queryOptions) string {
+	limit := ""
+	if o.maxRows > 0 {
+		limit = fmt.Sprintf(" LIMIT %d", o.maxRows)
+	}
+
+	return fmt.Sprintf(`SELECT %s FROM %s WHERE %s%s%s`,
+		r.selectFields, o.tableName, r.optionWhere(where, o), limit, o.lock)
+}
+
+func (r *PostgresRepository) buildExists(where string, o *queryOptions) string {
+	return fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE %s%s)`,
+		o.tableName, r.aggregateWhere(where, o), o.lock)
+}
+
+// buildCount never takes o.lock: a locking clause is not allowed with COUNT(*)
+func (r *PostgresRepository) buildCount(where string, o *queryOptions) string {
+	return fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE %s`,
+		o.tableName, r.aggregateWhere(where, o))
+}
+
+func (r *PostgresRepository) buildUpdate(fields string, where string, o *queryOptions) string {
+	return fmt.Sprintf(`
+		UPDATE %s %s SET %s
+				WHERE %s`, o.tableName, aliasConst, fields, r.optionWhere(where, o))
+}
+
+func (r *PostgresRepository) buildDelete(where string, o *queryOptions) string {
+	return fmt.Sprintf(`
+		UPDATE %s SET "deleted_at" = :deleted_at
+				WHERE %s`, o.tableName, r.optionWhere(where, o))
+}
+
+func (r *PostgresRepository) buildPermanentDelete(where string, o *queryOptions) string {
+	return fmt.Sprintf(`
+		DELETE FROM %s WHERE %s`, o.tableName, r.optionWhere(where, o))
+}
+
+// optionWhere ANDs where with the soft delete filter, when ExcludeDeleted is given,
+// and with the Predicate options. where is returned untouched without any of them.
+func (r *PostgresRepository) optionWhere(where string, o *queryOptions) string {
+	conditions := r.optionConditions(o)
+	if len(conditions) == 0 {
+		return where
+	}
+	if strings.TrimSpace(where) != "" {
+		conditions = append([]string{fmt.Sprintf("(%s)", where)}, conditions...)
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// aggregateWhere is optionWhere for Exists and Count, which also accept an empty where
+func (r *PostgresRepository) aggregateWhere(where string, o *queryOptions) string {
+	where = r.optionWhere(where, o)
+	if strings.TrimSpace(where) == "" {
+		return "TRUE"
+	}
+	return where
+}
+
+// softDeleteFilter is phrased exactly like the predicate of the
+// "WHERE deleted_at IS NULL" partial indexes so the planner can match them.
+const softDeleteFilter = "deleted_at IS NULL"
+
+// optionConditions returns the conditions added by the options
+func (r *PostgresRepository) optionConditions(o *queryOptions) []string {
+	conditions := []string{}
+	softDelete := o.excludeDeleted && r.softDelete
+	if softDelete {
+		conditions = append(conditions, softDeleteFilter)
+	}
+	for _, p := range o.predicates {
+		p = strings.TrimSpace(p)
+		if p == "" || (softDelete && p == softDeleteFilter) {
+			continue
+		}
+		conditions = append(conditions, fmt.Sprintf("(%s)", p))
+	}
+	return conditions
+}
@@ -0,0 +1,146 @@
+package data
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// assertGolden compares got with testdata/<name>.golden
+// Run "go test -update" to rewrite the golden files after an intended change.
+func assertGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	if got != string(want) {
+		t.Errorf("%s mismatch\ngot:\n%q\nwant:\n%q", name, got, string(want))
+	}
+}
+
+func TestBuildQueriesGolden(t *testing.T) {
+	r := NewPostgresRepository(nil, "items", testItem{})
+
+	cases := []struct {
+		name  string
+		query string
+	}{
+		{"insert", r.BuildInsert()},
+		{"upsert", r.BuildUpsert([]string{"name"})},
+		{"where", r.BuildWhere(`"code" = :code`)},
+		{"single", r.BuildSingle(`"id" = :id`)},
+		{"select_all", r.BuildSelectAll("", "10")},
+		{"update", r.BuildUpdate(`"name" = :name`, `"id" = :id`)},
+		{"delete", r.BuildDelete(`"id" = :id`)},
+		{"permanent_delete", r.BuildPermanentDelete(`"id" = :id`)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assertGolden(t, c.name, c.query)
+		})
+	}
+}
+
+// TestBuildQueriesExecuted checks the methods run the statements returned by the Build* methods
+func TestBuildQueriesExecuted(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	r := NewPostgresRepository(sqlxDB, "items", testItem{})
+	ctx := context.Background()
+	arg := map[string]interface{}{"id": 1}
+
+	bound := func(query string) string {
+		q, _, err := sqlx.Named(query, arg)
+		if err != nil {
+			t.Fatalf("sqlx.Named: %v", err)
+		}
+		return sqlxDB.Rebind(q)
+	}
+
+	mock.ExpectPrepare(bound(r.BuildWhere(`"id" = :id`))).
+		ExpectQuery().WillReturnRows(sqlmock.NewRows(testItemColumns))
+	mock.ExpectPrepare(bound(r.BuildPermanentDelete(`"id" = :id`))).
+		ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+
+	dest := []testItem{}
+	if err := r.Where(ctx, &dest, `"id" = :id`, arg); err != nil {
+		t.Errorf("Where: %v", err)
+	}
+	if err := r.PermanentDelete(ctx, `"id" = :id`, arg); err != nil {
+		t.Errorf("PermanentDelete: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildExistsCount(t *testing.T) {
+	r := NewPostgresRepository(nil, "items", testItem{})
+	noSoftDelete := NewPostgresRepository(nil, "items", testReadOnlyItem{})
+
+	cases := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			"exists soft delete",
+			r.BuildExists(`"code" = :code`),
+			`SELECT EXISTS (SELECT 1 FROM items WHERE ("code" = :code) AND deleted_at IS NULL)`,
+		},
+		{
+			"count soft delete",
+			r.BuildCount(`"code" = :code`),
+			`SELECT COUNT(*) FROM items WHERE ("code" = :code) AND deleted_at IS NULL`,
+		},
+		{
+			"duplicate soft delete predicate",
+			r.BuildCount(`"code" = :code`, Predicate("deleted_at IS NULL")),
+			`SELECT COUNT(*) FROM items WHERE ("code" = :code) AND deleted_at IS NULL`,
+		},
+		{
+			"extra predicate",
+			r.BuildExists("", Predicate(`"code" <> ''`)),
+			`SELECT EXISTS (SELECT 1 FROM items WHERE deleted_at IS NULL AND ("code" <> ''))`,
+		},
+		{
+			"no deleted_at column",
+			noSoftDelete.BuildCount(`"id" = :id`),
+			`SELECT COUNT(*) FROM items WHERE "id" = :id`,
+		},
+		{
+			"no deleted_at column with predicate",
+			noSoftDelete.BuildCount(`"id" = :id`, Predicate("deleted_at IS NULL")),
+			`SELECT COUNT(*) FROM items WHERE ("id" = :id) AND (deleted_at IS NULL)`,
+		},
+		{
+			"empty where",
+			noSoftDelete.BuildCount(""),
+			`SELECT COUNT(*) FROM items WHERE TRUE`,
+		},
+	}
+	for _, c := range cases {
+		if c.query != c.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", c.name, c.query, c.want)
+		}
+	}
+}
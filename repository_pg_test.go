@@ -0,0 +1,322 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+type testItem struct {
+	ID        int64      `db:"id"`
+	Name      string     `db:"name"`
+	Code      string     `db:"code"`
+	CreatedAt time.Time  `db:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at"`
+	DeletedAt *time.Time `db:"deleted_at"`
+}
+
+type testReadOnlyItem struct {
+	ID        int64     `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+var testItemColumns = []string{"id", "name", "code", "created_at", "updated_at", "deleted_at"}
+
+func newMockRepository(t *testing.T, elem interface{}) (*PostgresRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	return NewPostgresRepository(sqlx.NewDb(db, "postgres"), "items", elem), mock
+}
+
+func TestUpsertWithStatus(t *testing.T) {
+	r, mock := newMockRepository(t, testItem{})
+	now := time.Now()
+	item := testItem{Name: "a", Code: "x"}
+
+	cases := []struct {
+		name     string
+		id       int64
+		inserted bool
+	}{
+		{"insert", 1, true},
+		{"update", 1, false},
+	}
+	for _, c := range cases {
+		mock.ExpectPrepare(regexp.QuoteMeta(`ON CONFLICT ("name") DO UPDATE SET`)).
+			WillBeClosed().
+			ExpectQuery().
+			WithArgs("a", "x", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(append(testItemColumns, "inserted")).
+				AddRow(c.id, "a", "x", now, now, nil, c.inserted))
+
+		dest := testItem{}
+		inserted, err := r.UpsertWithStatus(context.Background(), item, []string{"name"}, &dest)
+		if err != nil {
+			t.Fatalf("%s: UpsertWithStatus: %v", c.name, err)
+		}
+		if inserted != c.inserted {
+			t.Errorf("%s: inserted = %v, want %v", c.name, inserted, c.inserted)
+		}
+		if dest.ID != c.id || dest.Name != "a" || dest.Code != "x" || !dest.UpdatedAt.Equal(now) {
+			t.Errorf("%s: dest not filled: %+v", c.name, dest)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpsertWithStatusNoUpdatableColumns(t *testing.T) {
+	r, _ := newMockRepository(t, testReadOnlyItem{})
+	dest := testReadOnlyItem{}
+	_, err := r.UpsertWithStatus(context.Background(), testReadOnlyItem{}, []string{"id"}, &dest)
+	if !errors.Is(err, ErrNoUpdatableColumns) {
+		t.Errorf("err = %v, want ErrNoUpdatableColumns", err)
+	}
+}
+
+func TestWhereMaps(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatalf("sqlmock.New: %v", err)
+	}
+	r := NewPostgresRepository(sqlx.NewDb(db, "postgres"), "items", testItem{})
+	now := time.Now()
+
+	mock.ExpectPrepare(`SELECT "id", "name", "code", "created_at", "updated_at", "deleted_at" FROM items WHERE ("code" = $1) AND deleted_at IS NULL`).
+		WillBeClosed().
+		ExpectQuery().
+		WithArgs("x").
+		WillReturnRows(sqlmock.NewRows(testItemColumns).
+			AddRow(int64(1), []byte("a"), []byte("x"), now, now, nil))
+	mock.ExpectPrepare(`SELECT "id", "name", "code", "created_at", "updated_at", "deleted_at" FROM items WHERE "code" = $1`).
+		WillBeClosed().
+		ExpectQuery().
+		WithArgs("x").
+		WillReturnRows(sqlmock.NewRows(testItemColumns).
+			AddRow(int64(1), []byte("a"), []byte("x"), now, now, nil).
+			AddRow(int64(2), []byte("b"), []byte("x"), now, now, now))
+
+	arg := map[string]interface{}{"code": "x"}
+	rows, err := r.WhereMaps(context.Background(), `"code" = :code`, arg)
+	if err != nil {
+		t.Fatalf("WhereMaps: %v", err)
+	}
+	if len(rows) != 1 {
+		t.Fatalf("WhereMaps returned %d rows, want 1", len(rows))
+	}
+	if len(rows[0]) != len(testItemColumns) {
+		t.Errorf("keys = %v, want %v", rows[0], testItemColumns)
+	}
+	for _, col := range testItemColumns {
+		if _, ok := rows[0][col]; !ok {
+			t.Errorf("missing key %s", col)
+		}
+	}
+	if name, ok := rows[0]["name"].(string); !ok || name != "a" {
+		t.Errorf(`rows[0]["name"] = %#v, want "a"`, rows[0]["name"])
+	}
+
+	rows, err = r.WhereMaps(context.Background(), `"code" = :code`, arg, IncludeDeleted())
+	if err != nil {
+		t.Fatalf("WhereMaps IncludeDeleted: %v", err)
+	}
+	if len(rows) != 2 || rows[1]["deleted_at"] == nil {
+		t.Errorf("WhereMaps IncludeDeleted = %v, want the deleted row too", rows)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func testItemStream(n int) chan interface{} {
+	in := make(chan interface{}, n)
+	for i := 0; i < n; i++ {
+		in <- testItem{Name: "a", Code: "x"}
+	}
+	return in
+}
+
+func TestInsertBulkStream(t *testing.T) {
+	r, mock := newMockRepository(t, testItem{})
+
+	// 4 bind values per row: name, code, created_at, updated_at
+	mock.ExpectBegin()
+	full := mock.ExpectPrepare(`INSERT INTO items .*\$400\)$`)
+	full.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 100))
+	full.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 100))
+	mock.ExpectPrepare(`INSERT INTO items .*\$200\)$`).
+		ExpectExec().WillReturnResult(sqlmock.NewResult(0, 50))
+	mock.ExpectCommit()
+
+	in := testItemStream(250)
+	close(in)
+	count, err := r.InsertBulkStream(context.Background(), in)
+	if err != nil {
+		t.Fatalf("InsertBulkStream: %v", err)
+	}
+	if count != 250 {
+		t.Errorf("count = %d, want 250", count)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInsertBulkStreamEmpty(t *testing.T) {
+	r, mock := newMockRepository(t, testItem{})
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	in := make(chan interface{})
+	close(in)
+	count, err := r.InsertBulkStream(context.Background(), in)
+	if err != nil || count != 0 {
+		t.Errorf("InsertBulkStream = %d, %v, want 0, nil", count, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInsertBulkStreamCancelled(t *testing.T) {
+	// The transaction is not even started with a cancelled context
+	r, mock := newMockRepository(t, testItem{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.InsertBulkStream(ctx, make(chan interface{}))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInsertBulkStreamStopsOnError(t *testing.T) {
+	r, mock := newMockRepository(t, testItem{})
+	execErr := errors.New("exec failed")
+	mock.ExpectBegin()
+	mock.ExpectPrepare(`INSERT INTO items`).
+		ExpectExec().WillReturnError(execErr)
+	mock.ExpectRollback()
+
+	in := testItemStream(150)
+	close(in)
+	_, err := r.InsertBulkStream(context.Background(), in)
+	if !errors.Is(err, execErr) {
+		t.Errorf("err = %v, want %v", err, execErr)
+	}
+	if len(in) != 50 {
+		t.Errorf("%d elements left in the channel, want 50", len(in))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInsertBulkStreamCommitError(t *testing.T) {
+	r, mock := newMockRepository(t, testItem{})
+	commitErr := errors.New("commit failed")
+	mock.ExpectBegin()
+	mock.ExpectPrepare(`INSERT INTO items`).
+		ExpectExec().WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit().WillReturnError(commitErr)
+
+	in := testItemStream(1)
+	close(in)
+	_, err := r.InsertBulkStream(context.Background(), in)
+	if !errors.Is(err, commitErr) {
+		t.Errorf("err = %v, want %v", err, commitErr)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPartialSetFields(t *testing.T) {
+	r := NewPostgresRepository(nil, "items", testItem{})
+
+	for _, columns := range [][]string{{}, {"id", "created_at", "updated_at"}} {
+		_, err := r.BuildUpdatePartial(columns, `"id" = :id`)
+		if !errors.Is(err, ErrNoUpdatableColumns) {
+			t.Errorf("%v: err = %v, want ErrNoUpdatableColumns", columns, err)
+		}
+	}
+
+	_, err := partialSetFields(r.elemType, []string{"name", "unknown"})
+	if err == nil || errors.Is(err, ErrNoUpdatableColumns) {
+		t.Errorf("unknown column: err = %v", err)
+	}
+
+	fields, err := partialSetFields(r.elemType, []string{"name", "id", "code"})
+	if err != nil {
+		t.Fatalf("partialSetFields: %v", err)
+	}
+	want := `"name" = :name,"code" = :code,"updated_at" = :updated_at`
+	if fields != want {
+		t.Errorf("fields = %s, want %s", fields, want)
+	}
+}
+
+func TestUpdateNoUpdatableColumns(t *testing.T) {
+	r := NewPostgresRepository(nil, "items", testItem{})
+	arg := map[string]interface{}{"id": 1}
+
+	for _, fields := range []string{
+		"", " ", `"updated_at" = :updated_at`, `A."updated_at" = now(), updated_at = now()`,
+		`"updated_at" = GREATEST(:updated_at, now())`,
+		`"updated_at" = COALESCE(:updated_at, 'a,b(')`,
+	} {
+		err := r.Update(context.Background(), fields, `"id" = :id`, arg)
+		if !errors.Is(err, ErrNoUpdatableColumns) {
+			t.Errorf("%q: err = %v, want ErrNoUpdatableColumns", fields, err)
+		}
+	}
+}
+
+func TestOnlyUpdatedAt(t *testing.T) {
+	cases := map[string]bool{
+		`"updated_at" = GREATEST(:updated_at, now())`:                 true,
+		`"name" = :name, "updated_at" = GREATEST(:updated_at, now())`: false,
+		`"name" = concat(:name, ','), "updated_at" = now()`:           false,
+		`"updated_at" = now(), "name" = :name`:                        false,
+	}
+	for fields, want := range cases {
+		if got := onlyUpdatedAt(fields); got != want {
+			t.Errorf("onlyUpdatedAt(%q) = %v, want %v", fields, got, want)
+		}
+	}
+}
+
+func TestInsertBulkStreamCancelledDuringBatch(t *testing.T) {
+	r, mock := newMockRepository(t, testItem{})
+	mock.ExpectBegin()
+	mock.ExpectPrepare(`INSERT INTO items`).
+		ExpectExec().WillDelayFor(time.Minute).WillReturnResult(sqlmock.NewResult(0, 100))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	in := testItemStream(100)
+	close(in)
+	start := time.Now()
+	_, err := r.InsertBulkStream(ctx, in)
+	if err == nil {
+		t.Fatal("InsertBulkStream should fail when the context is cancelled during a batch")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("InsertBulkStream returned after %v, the batch was not aborted", elapsed)
+	}
+}